@@ -0,0 +1,173 @@
+package xopen
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ArchiveEntry is a single file yielded while iterating over an archive
+// opened with OpenArchive. Reader is only valid until the next call to
+// Next or Close, which close it on the caller's behalf.
+type ArchiveEntry struct {
+	Name   string
+	Size   int64
+	Reader io.Reader
+}
+
+// ArchiveIter iterates over the entries of an archive opened with
+// OpenArchive. Next returns io.EOF once there are no more entries.
+type ArchiveIter interface {
+	Next() (*ArchiveEntry, error)
+	Close() error
+}
+
+// OpenArchive detects tar (optionally gzip/xz/zst/bzip2-compressed) and zip
+// inputs and returns an ArchiveIter over their entries, reusing xopen's
+// existing decompression pipeline for the tar case.
+func OpenArchive(path string) (ArchiveIter, error) {
+	var rdr io.Reader
+	var err error
+	if path == "-" {
+		if !IsStdin() {
+			return nil, errors.New("stdin not detected")
+		}
+		rdr = os.Stdin
+	} else {
+		rdr, err = XReader(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := bufio.NewReaderSize(rdr, bufSize)
+	isZip, err := CheckBytes(b, []byte{0x50, 0x4B, 0x03, 0x04})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if isZip || strings.HasSuffix(strings.ToLower(path), ".zip") {
+		return openZipArchive(b, rdr)
+	}
+	return openTarArchive(b, rdr)
+}
+
+func openTarArchive(b *bufio.Reader, rdr io.Reader) (ArchiveIter, error) {
+	r, err := Buf(b)
+	if err != nil {
+		return nil, err
+	}
+	r.rdr = rdr
+	return &tarIter{rdr: r, tr: tar.NewReader(r)}, nil
+}
+
+type tarIter struct {
+	rdr *Reader
+	tr  *tar.Reader
+}
+
+func (t *tarIter) Next() (*ArchiveEntry, error) {
+	for {
+		hdr, err := t.tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return &ArchiveEntry{Name: hdr.Name, Size: hdr.Size, Reader: t.tr}, nil
+		}
+	}
+}
+
+func (t *tarIter) Close() error {
+	return t.rdr.Close()
+}
+
+// openZipArchive builds a zip reader. archive/zip needs an io.ReaderAt plus
+// the total size, so non-seekable sources (http, stdin) are buffered to a
+// temp file first.
+func openZipArchive(b *bufio.Reader, rdr io.Reader) (ArchiveIter, error) {
+	if f, ok := rdr.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			zr, err := zip.NewReader(f, fi.Size())
+			if err != nil {
+				return nil, err
+			}
+			return &zipIter{zr: zr, files: zr.File, closer: f}, nil
+		}
+	}
+
+	tmp, err := ioutil.TempFile("", "xopen-archive-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if c, ok := rdr.(io.Closer); ok {
+		c.Close()
+	}
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &zipIter{zr: zr, files: zr.File, closer: tmp, tmpPath: tmp.Name()}, nil
+}
+
+type zipIter struct {
+	zr      *zip.Reader
+	files   []*zip.File
+	i       int
+	cur     io.ReadCloser // previous entry's reader, closed on the next Next/Close
+	closer  io.Closer
+	tmpPath string
+}
+
+func (z *zipIter) Next() (*ArchiveEntry, error) {
+	if z.cur != nil {
+		z.cur.Close()
+		z.cur = nil
+	}
+	for z.i < len(z.files) {
+		f := z.files[z.i]
+		z.i++
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		z.cur = rc
+		return &ArchiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64), Reader: rc}, nil
+	}
+	return nil, io.EOF
+}
+
+func (z *zipIter) Close() error {
+	if z.cur != nil {
+		z.cur.Close()
+		z.cur = nil
+	}
+	var err error
+	if z.closer != nil {
+		err = z.closer.Close()
+	}
+	if z.tmpPath != "" {
+		os.Remove(z.tmpPath)
+	}
+	return err
+}
@@ -0,0 +1,333 @@
+package xopen
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// bgzfBlockSize is the maximum uncompressed payload per BGZF block (64KiB,
+// minus a little headroom so worst-case-incompressible data still fits
+// the 16-bit BSIZE field), matching htslib's bgzf_write.
+const bgzfBlockSize = 65280
+
+// bgzfEOF is the 28-byte empty BGZF block bgzip appends to mark EOF.
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff,
+	0x06, 0x00, 0x42, 0x43, 0x02, 0x00, 0x1b, 0x00, 0x03, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// ErrNotBGZF means the stream being read isn't a valid BGZF block.
+var ErrNotBGZF = errors.New("xopen: not a bgzf block")
+
+// BGZFIndexEntry is one entry of a .gzi index: the compressed and
+// uncompressed offsets of a block boundary.
+type BGZFIndexEntry struct {
+	CompressedOffset   int64
+	UncompressedOffset int64
+}
+
+// BGZFIndex is a .gzi companion index, letting a virtual offset be found
+// without scanning every block from the start of the file.
+type BGZFIndex struct {
+	Entries []BGZFIndexEntry
+}
+
+// LoadBGZFIndex reads a .gzi index written by BGZFIndex.Save or bgzip -i.
+func LoadBGZFIndex(path string) (*BGZFIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var n uint64
+	if err := binary.Read(f, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	idx := &BGZFIndex{Entries: make([]BGZFIndexEntry, n)}
+	for i := range idx.Entries {
+		var c, u uint64
+		if err := binary.Read(f, binary.LittleEndian, &c); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(f, binary.LittleEndian, &u); err != nil {
+			return nil, err
+		}
+		idx.Entries[i] = BGZFIndexEntry{CompressedOffset: int64(c), UncompressedOffset: int64(u)}
+	}
+	return idx, nil
+}
+
+// Save writes idx as a .gzi index alongside the BGZF file it describes.
+func (idx *BGZFIndex) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := binary.Write(f, binary.LittleEndian, uint64(len(idx.Entries))); err != nil {
+		return err
+	}
+	for _, e := range idx.Entries {
+		if err := binary.Write(f, binary.LittleEndian, uint64(e.CompressedOffset)); err != nil {
+			return err
+		}
+		if err := binary.Write(f, binary.LittleEndian, uint64(e.UncompressedOffset)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BGZFWriter writes BGZF (blocked gzip): a sequence of independent gzip
+// members, each holding up to bgzfBlockSize bytes of uncompressed data,
+// so the result can later be seeked into with BGZFReader. Use WopenBGZF
+// or open a path ending in ".bgz" to get one through Wopen.
+type BGZFWriter struct {
+	w       io.Writer
+	buf     []byte
+	coffset int64 // compressed bytes written so far (start offset of the next block)
+	uoffset int64 // uncompressed bytes written so far
+	nblocks int
+	Index   *BGZFIndex
+}
+
+// NewBGZFWriter returns a BGZFWriter writing blocks to w.
+func NewBGZFWriter(w io.Writer) *BGZFWriter {
+	return &BGZFWriter{w: w, buf: make([]byte, 0, bgzfBlockSize), Index: &BGZFIndex{}}
+}
+
+// Write buffers p, emitting full BGZF blocks as they fill.
+func (z *BGZFWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		room := bgzfBlockSize - len(z.buf)
+		if room == 0 {
+			if err := z.flushBlock(); err != nil {
+				return n, err
+			}
+			room = bgzfBlockSize
+		}
+		take := room
+		if take > len(p) {
+			take = len(p)
+		}
+		z.buf = append(z.buf, p[:take]...)
+		p = p[take:]
+		n += take
+	}
+	return n, nil
+}
+
+func (z *BGZFWriter) flushBlock() error {
+	if len(z.buf) == 0 {
+		return nil
+	}
+	block, err := bgzfEncodeBlock(z.buf)
+	if err != nil {
+		return err
+	}
+	if _, err := z.w.Write(block); err != nil {
+		return err
+	}
+	// Like bgzip -i's .gzi, record the start offset of every block after
+	// the first (the first block's start, (0, 0), is implicit) and leave
+	// out the EOF marker block written separately by Close.
+	z.nblocks++
+	if z.nblocks > 1 {
+		z.Index.Entries = append(z.Index.Entries, BGZFIndexEntry{CompressedOffset: z.coffset, UncompressedOffset: z.uoffset})
+	}
+	z.coffset += int64(len(block))
+	z.uoffset += int64(len(z.buf))
+	z.buf = z.buf[:0]
+	return nil
+}
+
+// Flush emits the current partial block so a reader following along can
+// see the data written so far.
+func (z *BGZFWriter) Flush() error {
+	return z.flushBlock()
+}
+
+// Close flushes any buffered data and writes the BGZF EOF marker block.
+func (z *BGZFWriter) Close() error {
+	if err := z.flushBlock(); err != nil {
+		return err
+	}
+	_, err := z.w.Write(bgzfEOF)
+	return err
+}
+
+// bgzfEncodeBlock compresses data (<= bgzfBlockSize) into a single BGZF
+// member with its "BC" extra subfield's BSIZE patched to the member's
+// total size, since that size isn't known until the member is written.
+func bgzfEncodeBlock(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	gz.Header.Extra = []byte{'B', 'C', 2, 0, 0xff, 0xff}
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	block := buf.Bytes()
+	const bsizeOffset = 16 // 10-byte gzip header + 2-byte XLEN + 'B','C' + 2-byte SLEN
+	binary.LittleEndian.PutUint16(block[bsizeOffset:], uint16(len(block)-1))
+	return block, nil
+}
+
+// BGZFReader provides random access into a BGZF file via SeekVirtual, using
+// virtual offsets: the upper 48 bits select the compressed block offset,
+// the lower 16 bits an offset within that block's decompressed data.
+// Plain sequential reads of a BGZF stream don't need this - it's just
+// concatenated gzip, which Ropen/Buf already decode.
+type BGZFReader struct {
+	rs       io.ReadSeeker
+	file     *os.File // set by OpenBGZF, closed by Close
+	cur      []byte
+	coffset  int64
+	blockLen int64
+	uoffset  int
+}
+
+// NewBGZFReader returns a BGZFReader over rs, loading its first block.
+func NewBGZFReader(rs io.ReadSeeker) (*BGZFReader, error) {
+	z := &BGZFReader{rs: rs}
+	if err := z.loadBlockAt(0); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// OpenBGZF opens path for random-access BGZF reading.
+func OpenBGZF(path string) (*BGZFReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	z, err := NewBGZFReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	z.file = f
+	return z, nil
+}
+
+func (z *BGZFReader) loadBlockAt(coffset int64) error {
+	if _, err := z.rs.Seek(coffset, io.SeekStart); err != nil {
+		return err
+	}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(z.rs, header); err != nil {
+		return err
+	}
+	if header[0] != 0x1f || header[1] != 0x8b {
+		return ErrNotBGZF
+	}
+	xlen := int(header[10]) | int(header[11])<<8
+	extra := make([]byte, xlen)
+	if _, err := io.ReadFull(z.rs, extra); err != nil {
+		return err
+	}
+	bsize := -1
+	for i := 0; i+4 <= len(extra); {
+		si1, si2, slen := extra[i], extra[i+1], int(extra[i+2])|int(extra[i+3])<<8
+		if si1 == 'B' && si2 == 'C' && slen == 2 {
+			bsize = int(extra[i+4]) | int(extra[i+5])<<8
+		}
+		i += 4 + slen
+	}
+	if bsize < 0 {
+		return ErrNotBGZF
+	}
+
+	totalLen := int64(bsize + 1)
+	if totalLen < int64(len(header)+xlen)+8 {
+		return ErrNotBGZF // BSIZE too small to hold the header, extra field, and CRC32+ISIZE trailer
+	}
+	rest := make([]byte, totalLen-int64(len(header)+xlen))
+	if _, err := io.ReadFull(z.rs, rest); err != nil {
+		return err
+	}
+	isize := binary.LittleEndian.Uint32(rest[len(rest)-4:])
+	deflated := rest[:len(rest)-8]
+
+	data := make([]byte, isize)
+	if isize > 0 {
+		fr := flate.NewReader(bytes.NewReader(deflated))
+		if _, err := io.ReadFull(fr, data); err != nil {
+			fr.Close()
+			return err
+		}
+		fr.Close()
+	}
+
+	z.cur = data
+	z.coffset = coffset
+	z.blockLen = totalLen
+	z.uoffset = 0
+	return nil
+}
+
+// Read implements io.Reader, advancing through blocks as they're exhausted.
+func (z *BGZFReader) Read(p []byte) (int, error) {
+	if z.uoffset >= len(z.cur) {
+		if len(z.cur) == 0 && z.blockLen == 28 {
+			return 0, io.EOF // the BGZF EOF marker block
+		}
+		if err := z.loadBlockAt(z.coffset + z.blockLen); err != nil {
+			return 0, err
+		}
+		if len(z.cur) == 0 {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, z.cur[z.uoffset:])
+	z.uoffset += n
+	return n, nil
+}
+
+// SeekVirtual moves to the given BGZF virtual offset (coffset<<16 |
+// uoffset). It's named SeekVirtual rather than Seek because the argument
+// isn't a plain byte offset, so BGZFReader deliberately isn't an
+// io.Seeker.
+func (z *BGZFReader) SeekVirtual(virtualOffset int64) error {
+	coffset := virtualOffset >> 16
+	uoffset := int(virtualOffset & 0xffff)
+	if coffset != z.coffset || z.cur == nil {
+		if err := z.loadBlockAt(coffset); err != nil {
+			return err
+		}
+	}
+	if uoffset > len(z.cur) {
+		return errors.New("xopen: bgzf virtual offset out of range for its block")
+	}
+	z.uoffset = uoffset
+	return nil
+}
+
+// VirtualOffset returns the current position as a BGZF virtual offset.
+func (z *BGZFReader) VirtualOffset() int64 {
+	return z.coffset<<16 | int64(z.uoffset)
+}
+
+// Close releases the underlying file, if OpenBGZF opened it.
+func (z *BGZFReader) Close() error {
+	if z.file != nil {
+		return z.file.Close()
+	}
+	return nil
+}
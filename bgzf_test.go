@@ -0,0 +1,81 @@
+package xopen
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBGZFRoundTripAndSeekVirtual(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewBGZFWriter(&buf)
+
+	first := strings.Repeat("A", bgzfBlockSize)
+	second := "second-block-data"
+
+	if _, err := w.Write([]byte(first)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil { // force first into its own block
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.Index.Entries) != 1 {
+		t.Fatalf("expected 1 index entry (start of the 2nd block), got %d", len(w.Index.Entries))
+	}
+
+	r, err := NewBGZFReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := first + second; string(got) != want {
+		t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(want))
+	}
+
+	entry := w.Index.Entries[0]
+	r2, err := NewBGZFReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.SeekVirtual(entry.CompressedOffset << 16); err != nil {
+		t.Fatal(err)
+	}
+	got2, err := io.ReadAll(r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got2) != second {
+		t.Fatalf("SeekVirtual mismatch: got %q, want %q", got2, second)
+	}
+}
+
+func TestBGZFReaderRejectsTruncatedBlock(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewBGZFWriter(&buf)
+	if _, err := w.Write([]byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	// Corrupt the first block's BSIZE to claim a size smaller than its own
+	// header+extra+trailer, which used to panic on a negative make.
+	data[16], data[17] = 0x01, 0x00
+
+	if _, err := NewBGZFReader(bytes.NewReader(data)); err != ErrNotBGZF {
+		t.Fatalf("expected ErrNotBGZF for a corrupt block, got %v", err)
+	}
+}
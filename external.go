@@ -0,0 +1,112 @@
+package xopen
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// UseExternalCompressors, when true, makes Ropen/Wopen prefer fast
+// multi-threaded external binaries (pigz, pbzip2, pzstd) over the
+// in-process decoders whenever one is found on $PATH, following Docker's
+// lead in switching to pigz for parallel gzip. It silently falls back to
+// the in-process codec when the binary is missing.
+var UseExternalCompressors = false
+
+// ExternalThreads is the thread count passed to external compressors
+// (pigz/pbzip2/pzstd -p) when UseExternalCompressors is set. It defaults
+// to runtime.NumCPU().
+var ExternalThreads = runtime.NumCPU()
+
+// threadArgs returns the thread-count argument(s) for an external
+// (de)compressor. pbzip2 requires the count attached to -p (e.g. "-p4");
+// pigz and pzstd accept it as a separate argument.
+func threadArgs(name string, n int) []string {
+	if name == "pbzip2" {
+		return []string{"-p" + strconv.Itoa(n)}
+	}
+	return []string{"-p", strconv.Itoa(n)}
+}
+
+// pzstdLevelArg maps zstd.EncoderLevel to pzstd's -1..-19 CLI levels, per
+// the correspondence klauspost/compress documents between the two.
+func pzstdLevelArg(level zstd.EncoderLevel) string {
+	switch level {
+	case zstd.SpeedFastest:
+		return "-1"
+	case zstd.SpeedDefault:
+		return "-3"
+	case zstd.SpeedBetterCompression:
+		return "-9"
+	case zstd.SpeedBestCompression:
+		return "-19"
+	default:
+		return ""
+	}
+}
+
+// externalDecompressReader pipes src through "name -dc ..." and returns
+// the subprocess's stdout along with the *exec.Cmd and its captured stderr
+// so the caller can Wait on Close.
+func externalDecompressReader(src io.Reader, name string) (io.ReadCloser, *exec.Cmd, *bytes.Buffer, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	args := append([]string{"-dc"}, threadArgs(name, ExternalThreads)...)
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = src
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+	return stdout, cmd, &stderr, nil
+}
+
+// externalCompressWriter pipes a Writer's output through name into wtr,
+// applying o's thread count and compression level (when the tool supports
+// one), and returns a *Writer whose Close waits on the subprocess.
+func externalCompressWriter(wtr *os.File, name string, o WriteOptions) (*Writer, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, err
+	}
+	threads := ExternalThreads
+	if o.Threads > 0 {
+		threads = o.Threads
+	}
+	args := threadArgs(name, threads)
+	switch name {
+	case "pigz":
+		if o.GzipLevel > 0 {
+			args = append(args, "-"+strconv.Itoa(o.GzipLevel))
+		}
+	case "pzstd":
+		if a := pzstdLevelArg(o.ZstdLevel); a != "" {
+			args = append(args, a)
+		}
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = wtr
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &Writer{Writer: bufio.NewWriterSize(stdin, bufSize), wtr: wtr, enc: stdin, cmd: cmd, errBuf: &stderr}, nil
+}
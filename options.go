@@ -0,0 +1,324 @@
+package xopen
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// ReadOptions configures the decompressor RopenOpts/bufOpts construct.
+// The zero value keeps the previous Ropen/Buf behavior.
+type ReadOptions struct {
+	// ZstdConcurrency sets the number of goroutines the zstd decoder may
+	// use. Zero means the library default.
+	ZstdConcurrency int
+	// MaxMemory caps the memory the zstd decoder may allocate per frame,
+	// in bytes. Zero means the library default.
+	MaxMemory uint64
+	// BufSize overrides the package's default buffered reader size.
+	BufSize int
+}
+
+// WriteOptions configures the compressor WopenFileOpts constructs.
+// The zero value keeps the previous Wopen/WopenFile behavior.
+type WriteOptions struct {
+	// GzipLevel is passed to pgzip.NewWriterLevel. Zero means the
+	// library default (gzip.DefaultCompression).
+	GzipLevel int
+	// ZstdLevel is passed to zstd.WithEncoderLevel. Zero means the
+	// library default.
+	ZstdLevel zstd.EncoderLevel
+	// XzPreset is an xz/lzma preset in the 1-9 range, matching the
+	// dictionary size xz -<preset> uses. Zero means the library default,
+	// not xz's own -0 preset (which this type can't represent).
+	XzPreset int
+	// Threads sets pgzip's SetConcurrency block count and zstd's
+	// WithEncoderConcurrency. Zero means the library default.
+	Threads int
+	// BufSize overrides the package's default buffered writer size.
+	BufSize int
+}
+
+func bufSizeOf(n int) int {
+	if n > 0 {
+		return n
+	}
+	return bufSize
+}
+
+// bufOpts is Buf with decoder options; Buf calls it with the zero value.
+func bufOpts(r io.Reader, o ReadOptions) (*Reader, error) {
+	size := bufSizeOf(o.BufSize)
+	b := bufio.NewReaderSize(r, size)
+	var rd io.Reader
+	var rdr io.ReadCloser
+	var cmd *exec.Cmd
+	var errBuf *bytes.Buffer
+	if is, err := IsGzip(b); err != nil && err != io.EOF {
+		return nil, err
+	} else if is {
+		if UseExternalCompressors {
+			if rc, c, eb, err := externalDecompressReader(b, "pigz"); err == nil {
+				rdr, cmd, errBuf = rc, c, eb
+				b = bufio.NewReaderSize(rdr, size)
+				return finishBuf(b, r, rdr, cmd, errBuf)
+			}
+		}
+		rdr, err = gzip.NewReader(b)
+		if err != nil {
+			return nil, err
+		}
+		b = bufio.NewReaderSize(rdr, size)
+	} else if is, err := IsXz(b); err != nil && err != io.EOF {
+		return nil, err
+	} else if is {
+		rd, err = xz.NewReader(b)
+		if err != nil {
+			return nil, err
+		}
+		b = bufio.NewReaderSize(rd, size)
+	} else if is, err := IsZst(b); err != nil && err != io.EOF {
+		return nil, err
+	} else if is {
+		if UseExternalCompressors {
+			if rc, c, eb, err := externalDecompressReader(b, "pzstd"); err == nil {
+				rdr, cmd, errBuf = rc, c, eb
+				b = bufio.NewReaderSize(rdr, size)
+				return finishBuf(b, r, rdr, cmd, errBuf)
+			}
+		}
+		var zopts []zstd.DOption
+		if o.ZstdConcurrency > 0 {
+			zopts = append(zopts, zstd.WithDecoderConcurrency(o.ZstdConcurrency))
+		}
+		if o.MaxMemory > 0 {
+			zopts = append(zopts, zstd.WithDecoderMaxMemory(o.MaxMemory))
+		}
+		rd, err = zstd.NewReader(b, zopts...)
+		if err != nil {
+			return nil, err
+		}
+		b = bufio.NewReaderSize(rd, size)
+	} else if is, err := IsBzip2(b); err != nil && err != io.EOF {
+		return nil, err
+	} else if is {
+		if UseExternalCompressors {
+			if rc, c, eb, err := externalDecompressReader(b, "pbzip2"); err == nil {
+				rdr, cmd, errBuf = rc, c, eb
+				b = bufio.NewReaderSize(rdr, size)
+				return finishBuf(b, r, rdr, cmd, errBuf)
+			}
+		}
+		rd = bzip2.NewReader(b)
+		b = bufio.NewReaderSize(rd, size)
+	} else if is, err := IsLz4(b); err != nil && err != io.EOF {
+		return nil, err
+	} else if is {
+		rd = lz4.NewReader(b)
+		b = bufio.NewReaderSize(rd, size)
+	}
+
+	return finishBuf(b, r, rdr, cmd, errBuf)
+}
+
+// RopenOpts is Ropen with decoder options; Ropen calls it with the zero value.
+func RopenOpts(f string, o ReadOptions) (*Reader, error) {
+	if f == "-" {
+		if !IsStdin() {
+			return nil, errors.New("stdin not detected")
+		}
+		return bufOpts(os.Stdin, o)
+	} else if f[0] == '|' {
+		return pipeReader(f[1:], o)
+	}
+	rdr, err := XReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return bufOpts(rdr, o)
+}
+
+// pipeReader runs spec as a subprocess and returns a Reader over its
+// stdout; Close waits on the subprocess and surfaces any captured stderr
+// on a non-zero exit.
+func pipeReader(spec string, o ReadOptions) (*Reader, error) {
+	cmd, err := pipeCommand(spec)
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	r, err := bufOpts(stdout, o)
+	if err != nil {
+		return nil, err
+	}
+	r.cmd = cmd
+	r.errBuf = &stderr
+	return r, nil
+}
+
+// xzPresetDictCap maps an xz/lzma preset to the dictionary size
+// xz -<preset> uses: 1:1MiB, 2:2MiB, 3-4:4MiB, 5-6:8MiB (xz's own
+// default), 7:16MiB, 8:32MiB, 9:64MiB. 0 means "use the library
+// default" (see WriteOptions.XzPreset), not xz's -0 preset.
+func xzPresetDictCap(preset int) uint32 {
+	switch {
+	case preset <= 0:
+		return 0
+	case preset == 1:
+		return 1 << 20
+	case preset == 2:
+		return 1 << 21
+	case preset <= 4:
+		return 1 << 22
+	case preset <= 6:
+		return 1 << 23
+	case preset == 7:
+		return 1 << 24
+	case preset == 8:
+		return 1 << 25
+	default: // 9 and above
+		return 1 << 26
+	}
+}
+
+// WopenFileOpts is WopenFile with encoder options; WopenFile calls it with
+// the zero value.
+func WopenFileOpts(f string, flag int, perm os.FileMode, o WriteOptions) (*Writer, error) {
+	if f != "" && f[0] == '|' {
+		return openPipeWriter(f[1:], o)
+	}
+	wtr, err := openOutputFile(f, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	size := bufSizeOf(o.BufSize)
+	f2 := strings.ToLower(f)
+	if strings.HasSuffix(f2, ".gz") && !strings.HasSuffix(f2, ".bgz") {
+		if UseExternalCompressors {
+			if w, err := externalCompressWriter(wtr, "pigz", o); err == nil {
+				return w, nil
+			}
+		}
+		level := o.GzipLevel
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gz, err := gzip.NewWriterLevel(wtr, level)
+		if err != nil {
+			return nil, err
+		}
+		if o.Threads > 0 {
+			gz.SetConcurrency(size, o.Threads)
+		}
+		return &Writer{Writer: bufio.NewWriterSize(gz, size), wtr: wtr, enc: gz}, nil
+	}
+	if strings.HasSuffix(f2, ".xz") {
+		var cfg xz.WriterConfig
+		if dictCap := xzPresetDictCap(o.XzPreset); dictCap > 0 {
+			cfg.DictCap = int(dictCap)
+		}
+		xw, err := cfg.NewWriter(wtr)
+		return &Writer{Writer: bufio.NewWriterSize(xw, size), wtr: wtr, enc: xw}, err
+	}
+	if strings.HasSuffix(f2, ".zst") {
+		if UseExternalCompressors {
+			if w, err := externalCompressWriter(wtr, "pzstd", o); err == nil {
+				return w, nil
+			}
+		}
+		var zopts []zstd.EOption
+		if o.ZstdLevel != 0 {
+			zopts = append(zopts, zstd.WithEncoderLevel(o.ZstdLevel))
+		}
+		if o.Threads > 0 {
+			zopts = append(zopts, zstd.WithEncoderConcurrency(o.Threads))
+		}
+		zw, err := zstd.NewWriter(wtr, zopts...)
+		return &Writer{Writer: bufio.NewWriterSize(zw, size), wtr: wtr, enc: zw}, err
+	}
+	if strings.HasSuffix(f2, ".lz4") {
+		lw := lz4.NewWriter(wtr)
+		return &Writer{Writer: bufio.NewWriterSize(lw, size), wtr: wtr, enc: lw}, nil
+	}
+	if strings.HasSuffix(f2, ".bgz") {
+		bz := NewBGZFWriter(wtr)
+		return &Writer{Writer: bufio.NewWriterSize(bz, size), wtr: wtr, enc: bz}, nil
+	}
+	return &Writer{Writer: bufio.NewWriterSize(wtr, size), wtr: wtr}, nil
+}
+
+// WopenBGZF opens f for BGZF-blocked gzip writing (see BGZFWriter),
+// regardless of its extension - unlike WopenFile's ".bgz" suffix dispatch,
+// this is for callers that want BGZF explicitly, e.g. to write a ".bam".
+func WopenBGZF(f string) (*Writer, error) {
+	wtr, err := openOutputFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	bz := NewBGZFWriter(wtr)
+	return &Writer{Writer: bufio.NewWriterSize(bz, bufSize), wtr: wtr, enc: bz}, nil
+}
+
+// openOutputFile opens f for writing, creating its parent directory if
+// needed, the same way WopenFileOpts does for a plain path.
+func openOutputFile(f string, flag int, perm os.FileMode) (*os.File, error) {
+	if f == "-" {
+		return os.Stdout, nil
+	}
+	dir := filepath.Dir(f)
+	fi, err := os.Stat(dir)
+	if err == nil && !fi.IsDir() {
+		return nil, fmt.Errorf("can not write file into a non-directory path: %s", dir)
+	}
+	if os.IsNotExist(err) {
+		os.MkdirAll(dir, 0755)
+	}
+	return os.OpenFile(f, flag, perm)
+}
+
+// openPipeWriter pipes a buffered writer into spec's stdin. The Writer has
+// no way to hand back the subprocess's stdout, so spec's stdout is just
+// inherited from the current process (useful for the subprocess's own
+// diagnostics, not for capturing its output) - spec must write its result
+// somewhere the caller already controls, e.g.
+// Wopen("|bcftools view -Oz -o out.vcf.gz"), not a "-c"-style command that
+// only knows how to write its result to stdout.
+func openPipeWriter(spec string, o WriteOptions) (*Writer, error) {
+	cmd, err := pipeCommand(spec)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = os.Stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	size := bufSizeOf(o.BufSize)
+	return &Writer{Writer: bufio.NewWriterSize(stdin, size), enc: stdin, cmd: cmd, errBuf: &stderr}, nil
+}
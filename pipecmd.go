@@ -0,0 +1,72 @@
+package xopen
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// shlexSplit tokenizes s the way a shell would, honoring single and double
+// quotes and backslash escapes, so a pipe spec like
+// `|samtools view -b -o 'my file.bam'` splits into the right argv.
+func shlexSplit(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote != 0:
+			switch {
+			case c == quote:
+				quote = 0
+			case c == '\\' && quote == '"' && i+1 < len(runes):
+				i++
+				cur.WriteRune(runes[i])
+			default:
+				cur.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("xopen: trailing backslash in command: %q", s)
+			}
+			i++
+			cur.WriteRune(runes[i])
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("xopen: unterminated %c quote in command: %q", quote, s)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// pipeCommand builds the *exec.Cmd for a Ropen("|...")/Wopen("|...") spec.
+func pipeCommand(spec string) (*exec.Cmd, error) {
+	args, err := shlexSplit(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("xopen: empty command")
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
@@ -0,0 +1,40 @@
+package xopen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShlexSplit(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "samtools view -b", want: []string{"samtools", "view", "-b"}},
+		{in: "  pad  spacing   here ", want: []string{"pad", "spacing", "here"}},
+		{in: `samtools view -o 'my file.bam'`, want: []string{"samtools", "view", "-o", "my file.bam"}},
+		{in: `echo "a b" c`, want: []string{"echo", "a b", "c"}},
+		{in: `echo "a\"b"`, want: []string{"echo", `a"b`}},
+		{in: `echo a\ b`, want: []string{"echo", "a b"}},
+		{in: "", want: nil},
+		{in: `echo 'unterminated`, wantErr: true},
+		{in: `echo trailing\`, wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := shlexSplit(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("shlexSplit(%q): expected error, got %#v", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("shlexSplit(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("shlexSplit(%q) = %#v, want %#v", c.in, got, c.want)
+		}
+	}
+}
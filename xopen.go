@@ -6,19 +6,14 @@ package xopen
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
-	"path/filepath"
 	"strings"
-
-	"github.com/klauspost/compress/zstd"
-	gzip "github.com/klauspost/pgzip"
-	"github.com/ulikunitz/xz"
 )
 
 // ErrNoContent means nothing in the stream/file.
@@ -42,6 +37,16 @@ func IsZst(b *bufio.Reader) (bool, error) {
 	return CheckBytes(b, []byte{0x28, 0xB5, 0x2f, 0xfd})
 }
 
+// IsBzip2 returns true buffered Reader has the bzip2 magic ("BZh").
+func IsBzip2(b *bufio.Reader) (bool, error) {
+	return CheckBytes(b, []byte{0x42, 0x5A, 0x68})
+}
+
+// IsLz4 returns true buffered Reader has the lz4 frame magic.
+func IsLz4(b *bufio.Reader) (bool, error) {
+	return CheckBytes(b, []byte{0x04, 0x22, 0x4D, 0x18})
+}
+
 // IsStdin checks if we are getting data from stdin.
 func IsStdin() bool {
 	// http://stackoverflow.com/a/26567513
@@ -101,8 +106,10 @@ func CheckBytes(b *bufio.Reader, buf []byte) (bool, error) {
 // Reader is returned by Ropen
 type Reader struct {
 	*bufio.Reader
-	rdr io.Reader
-	gz  io.ReadCloser
+	rdr    io.Reader
+	gz     io.ReadCloser
+	cmd    *exec.Cmd     // set when reading from a subprocess's stdout (external decompressor or "|cmd")
+	errBuf *bytes.Buffer // captures cmd's stderr, surfaced on a non-zero exit
 }
 
 // Close the associated files.
@@ -113,42 +120,53 @@ func (r *Reader) Close() error {
 	if c, ok := r.rdr.(io.ReadCloser); ok {
 		c.Close()
 	}
+	if r.cmd != nil {
+		if err := r.cmd.Wait(); err != nil {
+			return wrapCmdErr(err, r.errBuf)
+		}
+	}
 	return nil
 }
 
 // Writer is returned by Wopen
 type Writer struct {
 	*bufio.Writer
-	wtr *os.File
-	gz  *gzip.Writer
-	xw  *xz.Writer
-	zw  *zstd.Encoder
+	wtr    *os.File
+	enc    io.WriteCloser // the compressor or a subprocess's stdin, nil for plain file output
+	cmd    *exec.Cmd      // set when enc pipes into a subprocess (external compressor or "|cmd")
+	errBuf *bytes.Buffer  // captures cmd's stderr, surfaced on a non-zero exit
 }
 
 // Close the associated files.
 func (w *Writer) Close() error {
 	w.Flush()
-	if w.gz != nil {
-		w.gz.Close()
+	if w.enc != nil {
+		w.enc.Close()
 	}
-	if w.xw != nil {
-		w.xw.Close()
+	if w.cmd != nil {
+		if err := w.cmd.Wait(); err != nil {
+			return wrapCmdErr(err, w.errBuf)
+		}
 	}
-	if w.zw != nil {
-		w.zw.Close()
+	if w.wtr != nil {
+		w.wtr.Close()
 	}
-	w.wtr.Close()
 	return nil
 }
 
+// wrapCmdErr appends a subprocess's captured stderr to its exit error.
+func wrapCmdErr(err error, errBuf *bytes.Buffer) error {
+	if errBuf == nil || errBuf.Len() == 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %s", err, strings.TrimSpace(errBuf.String()))
+}
+
 // Flush the writer.
 func (w *Writer) Flush() {
 	w.Writer.Flush()
-	if w.gz != nil {
-		w.gz.Flush()
-	}
-	if w.zw != nil {
-		w.zw.Flush()
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
 	}
 }
 
@@ -158,36 +176,11 @@ var bufSize = 65536
 // If f == "-", then it will attempt to read from os.Stdin.
 // If the file is gzipped, it will be read as such.
 func Buf(r io.Reader) (*Reader, error) {
-	b := bufio.NewReaderSize(r, bufSize)
-	var rd io.Reader
-	var rdr io.ReadCloser
-	if is, err := IsGzip(b); err != nil && err != io.EOF {
-		return nil, err
-	} else if is {
-		rdr, err = gzip.NewReader(b)
-		if err != nil {
-			return nil, err
-		}
-		b = bufio.NewReaderSize(rdr, bufSize)
-	} else if is, err := IsXz(b); err != nil && err != io.EOF {
-		return nil, err
-	} else if is {
-		rd, err = xz.NewReader(b)
-		if err != nil {
-			return nil, err
-		}
-		b = bufio.NewReaderSize(rd, bufSize)
-	} else if is, err := IsZst(b); err != nil && err != io.EOF {
-		return nil, err
-	} else if is {
-		rd, err = zstd.NewReader(b)
-		if err != nil {
-			return nil, err
-		}
-		b = bufio.NewReaderSize(rd, bufSize)
-	}
+	return bufOpts(r, ReadOptions{})
+}
 
-	// check BOM
+// finishBuf strips a leading BOM, if any, and wraps up the Reader.
+func finishBuf(b *bufio.Reader, r io.Reader, gz io.ReadCloser, cmd *exec.Cmd, errBuf *bytes.Buffer) (*Reader, error) {
 	t, _, err := b.ReadRune()
 	if err != nil {
 		return nil, ErrNoContent
@@ -195,22 +188,21 @@ func Buf(r io.Reader) (*Reader, error) {
 	if t != '\uFEFF' {
 		b.UnreadRune()
 	}
-	return &Reader{b, r, rdr}, nil
+	return &Reader{Reader: b, rdr: r, gz: gz, cmd: cmd, errBuf: errBuf}, nil
 }
 
-// XReader returns a reader from a url string or a file.
+// XReader returns a reader from a url string or a file. http(s) URLs are
+// fetched with HTTPClient/HTTPHeader; URLs whose scheme was registered
+// with RegisterScheme (e.g. "s3://", "gs://") are handed to that scheme's
+// opener.
 func XReader(f string) (io.Reader, error) {
 	if strings.HasPrefix(f, "http://") || strings.HasPrefix(f, "https://") {
-		var rsp *http.Response
-		rsp, err := http.Get(f)
-		if err != nil {
-			return nil, err
-		}
-		if rsp.StatusCode != 200 {
-			return nil, fmt.Errorf("http error downloading %s. status: %s", f, rsp.Status)
+		return httpGet(f)
+	}
+	for prefix, opener := range schemeOpeners {
+		if strings.HasPrefix(f, prefix) {
+			return opener(f)
 		}
-		rdr := rsp.Body
-		return rdr, nil
 	}
 	f, err := ExpandUser(f)
 	if err != nil {
@@ -229,47 +221,27 @@ func XReader(f string) (io.Reader, error) {
 }
 
 // Ropen opens a buffered reader.
+// If f starts with "|", the rest is parsed shell-style (quotes and
+// backslash escapes are honored) and run as a subprocess whose stdout is
+// read; Close waits on the subprocess and returns its stderr on a
+// non-zero exit.
+// Use RopenOpts to control the zstd decoder's concurrency and memory use.
 func Ropen(f string) (*Reader, error) {
-	var err error
-	var rdr io.Reader
-	if f == "-" {
-		if !IsStdin() {
-			return nil, errors.New("stdin not detected")
-		}
-		b, err := Buf(os.Stdin)
-		return b, err
-	} else if f[0] == '|' {
-		// TODO: use csv to handle quoted file names.
-		cmdStrs := strings.Split(f[1:], " ")
-		var cmd *exec.Cmd
-		if len(cmdStrs) == 2 {
-			cmd = exec.Command(cmdStrs[0], cmdStrs[1:]...)
-		} else {
-			cmd = exec.Command(cmdStrs[0])
-		}
-		rdr, err = cmd.StdoutPipe()
-		if err != nil {
-			return nil, err
-		}
-		err = cmd.Start()
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		rdr, err = XReader(f)
-	}
-	if err != nil {
-		return nil, err
-	}
-	b, err := Buf(rdr)
-	return b, err
+	return RopenOpts(f, ReadOptions{})
 }
 
 // Wopen opens a buffered reader.
 // If f == "-", then stdout will be used.
+// If f starts with "|", the rest is parsed shell-style and run as a
+// subprocess whose stdin receives the written bytes; the subprocess's own
+// stdout is just inherited, not captured, so it must write its result
+// somewhere else, e.g. Wopen("|bcftools view -Oz -o out.vcf.gz").
 // If f endswith ".gz", then the output will be gzipped.
 // If f endswith ".xz", then the output will be zx-compressed.
 // If f endswith ".zst", then the output will be zstd-compressed.
+// If f endswith ".lz4", then the output will be lz4-compressed.
+// If f endswith ".bgz", then the output will be written as BGZF (see
+// BGZFWriter), allowing later random access with BGZFReader.
 func Wopen(f string) (*Writer, error) {
 	return WopenFile(f, os.O_RDONLY, 0)
 }
@@ -279,37 +251,9 @@ func Wopen(f string) (*Writer, error) {
 // If f endswith ".gz", then the output will be gzipped.
 // If f endswith ".xz", then the output will be zx-compressed.
 // If f endswith ".zst", then the output will be zstd-compressed.
+// If f endswith ".lz4", then the output will be lz4-compressed.
+// If f endswith ".bgz", then the output will be written as BGZF.
+// Use WopenFileOpts to control compression level and concurrency.
 func WopenFile(f string, flag int, perm os.FileMode) (*Writer, error) {
-	var wtr *os.File
-	if f == "-" {
-		wtr = os.Stdout
-	} else {
-		dir := filepath.Dir(f)
-		fi, err := os.Stat(dir)
-		if err == nil && !fi.IsDir() {
-			return nil, fmt.Errorf("can not write file into a non-directory path: %s", dir)
-		}
-		if os.IsNotExist(err) {
-			os.MkdirAll(dir, 0755)
-		}
-		wtr, err = os.OpenFile(f, flag, perm)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	f2 := strings.ToLower(f)
-	if strings.HasSuffix(f2, ".gz") {
-		gz := gzip.NewWriter(wtr)
-		return &Writer{bufio.NewWriterSize(gz, bufSize), wtr, gz, nil, nil}, nil
-	}
-	if strings.HasSuffix(f2, ".xz") {
-		xw, err := xz.NewWriter(wtr)
-		return &Writer{bufio.NewWriterSize(xw, bufSize), wtr, nil, xw, nil}, err
-	}
-	if strings.HasSuffix(f2, ".zst") {
-		zw, err := zstd.NewWriter(wtr)
-		return &Writer{bufio.NewWriterSize(zw, bufSize), wtr, nil, nil, zw}, err
-	}
-	return &Writer{bufio.NewWriterSize(wtr, bufSize), wtr, nil, nil, nil}, nil
+	return WopenFileOpts(f, flag, perm, WriteOptions{})
 }